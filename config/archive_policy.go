@@ -0,0 +1,37 @@
+package config
+
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ArchiveAction is what should happen to a completed workflow that matches
+// an ArchivePolicy rule.
+type ArchiveAction string
+
+const (
+	ArchiveActionArchive     ArchiveAction = "Archive"
+	ArchiveActionSkip        ArchiveAction = "Skip"
+	ArchiveActionDeleteAfter ArchiveAction = "DeleteAfter"
+)
+
+// ArchivePolicy is one rule in the ordered policy list evaluated by
+// WorkflowController.EffectiveArchivePolicy. The first rule whose selectors
+// both match wins. It hangs off Config as ArchivePolicies.
+type ArchivePolicy struct {
+	// NamespaceSelector matches labels on the Namespace object the workflow
+	// lives in. A nil selector matches every namespace.
+	NamespaceSelector *metav1.LabelSelector
+	// WorkflowSelector matches labels on the Workflow itself. A nil selector
+	// matches every workflow.
+	WorkflowSelector *metav1.LabelSelector
+	TTL              metav1.Duration
+	Action           ArchiveAction
+}
+
+// DeleteAfterDeadline returns when an archived workflow matching this
+// DeleteAfter rule should be purged, measured from the time it completed.
+func (p *ArchivePolicy) DeleteAfterDeadline(completedAt time.Time) time.Time {
+	return completedAt.Add(p.TTL.Duration)
+}
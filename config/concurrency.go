@@ -0,0 +1,30 @@
+package config
+
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DefaultQueueSaturationWarningWindow is used when
+// ConcurrencyConfig.SaturationWarningWindow is unset.
+const DefaultQueueSaturationWarningWindow = 1 * time.Minute
+
+// ConcurrencyConfig lets operators tune per-queue worker counts without
+// recompiling. Zero values fall back to the controller's historical
+// hardcoded defaults. It hangs off Config as Concurrency.
+type ConcurrencyConfig struct {
+	WorkflowWorkers       int
+	PodWorkers            int
+	PodCleanupWorkers     int
+	CronWorkers           int
+	GarbageCollectWorkers int
+	ArchiveWorkers        int
+
+	// SaturationWarningWindow is how long a queue must stay fully busy
+	// (depth > 0 with every worker occupied) before the controller logs a
+	// saturation warning and counts it in a metric; no Kubernetes Event is
+	// recorded. Zero falls back to
+	// DefaultQueueSaturationWarningWindow.
+	SaturationWarningWindow metav1.Duration
+}
@@ -0,0 +1,40 @@
+// Package config defines the workflow controller's runtime configuration,
+// as loaded from the workflow-controller-configmap ConfigMap.
+package config
+
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	wfv1 "github.com/argoproj/argo/pkg/apis/workflow/v1alpha1"
+)
+
+// DefaultPodPendingThreshold is used when Config.PodPendingThreshold is unset.
+const DefaultPodPendingThreshold = 30 * time.Second
+
+// Config is the workflow controller's configuration.
+type Config struct {
+	// ExecutorImage is the image used for the wait/init containers injected
+	// into every workflow pod.
+	ExecutorImage string
+
+	// WorkflowDefaults, if set, is merged onto every Workflow's spec, labels
+	// and annotations for any field the Workflow doesn't already set itself.
+	WorkflowDefaults *wfv1.Workflow
+
+	// PodPendingThreshold is how long a pod may sit in Pending before the
+	// controller starts consulting its cached pod events for a more
+	// specific NodeStatus message, instead of assuming it's still just
+	// waiting to be scheduled. Zero falls back to DefaultPodPendingThreshold.
+	PodPendingThreshold metav1.Duration
+
+	// Concurrency tunes the controller's per-queue worker pool sizes.
+	Concurrency ConcurrencyConfig
+
+	// ArchivePolicies is the ordered list of rules EffectiveArchivePolicy
+	// evaluates to decide whether (and for how long) a completed workflow
+	// should be archived. An empty list falls back to the controller's
+	// legacy single archiveLabelSelector behavior.
+	ArchivePolicies []ArchivePolicy
+}
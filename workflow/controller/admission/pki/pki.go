@@ -0,0 +1,166 @@
+// Package pki generates and rotates the self-signed CA and serving
+// certificate the admission webhook server presents to the API server.
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// certValidity is how long the generated serving cert is valid for.
+// NeedsRotation reports true once less than rotateBefore remains.
+const (
+	certValidity = 365 * 24 * time.Hour
+	rotateBefore = 30 * 24 * time.Hour
+)
+
+// KeyPair is a PEM-encoded certificate and private key.
+type KeyPair struct {
+	CertPEM []byte
+	KeyPEM  []byte
+}
+
+// Bundle is the self-signed CA plus the serving cert it issued for a
+// webhook's service DNS name.
+type Bundle struct {
+	CA      KeyPair
+	Serving KeyPair
+	caCert  *x509.Certificate
+	caKey   *ecdsa.PrivateKey
+	expiry  time.Time
+}
+
+// Generate creates a fresh self-signed CA and a serving certificate for the
+// given service DNS names (typically "<svc>.<namespace>.svc").
+func Generate(dnsNames ...string) (*Bundle, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generating CA key: %w", err)
+	}
+	notBefore := time.Now()
+	notAfter := notBefore.Add(certValidity)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "argo-workflows-webhook-ca"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("creating CA cert: %w", err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, err
+	}
+
+	servingKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generating serving key: %w", err)
+	}
+	servingTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     dnsNames,
+	}
+	servingDER, err := x509.CreateCertificate(rand.Reader, servingTemplate, caCert, &servingKey.PublicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("creating serving cert: %w", err)
+	}
+
+	servingKeyDER, err := x509.MarshalECPrivateKey(servingKey)
+	if err != nil {
+		return nil, err
+	}
+	caKeyDER, err := x509.MarshalECPrivateKey(caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Bundle{
+		CA: KeyPair{
+			CertPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+			KeyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: caKeyDER}),
+		},
+		Serving: KeyPair{
+			CertPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: servingDER}),
+			KeyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: servingKeyDER}),
+		},
+		caCert: caCert,
+		caKey:  caKey,
+		expiry: notAfter,
+	}, nil
+}
+
+// NeedsRotation reports whether the serving cert is within rotateBefore of
+// expiry.
+func (b *Bundle) NeedsRotation() bool {
+	return time.Until(b.expiry) < rotateBefore
+}
+
+// LoadSecret reads a previously persisted Bundle back out of the named
+// Secret. A missing Secret comes back as the API server's NotFound error,
+// unwrapped, so callers can tell "never provisioned yet" apart from a real
+// failure.
+func LoadSecret(kubeclientset kubernetes.Interface, namespace, name string) (*Bundle, error) {
+	secret, err := kubeclientset.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(secret.Data["ca.crt"])
+	if block == nil {
+		return nil, fmt.Errorf("secret %s/%s has no ca.crt", namespace, name)
+	}
+	caCert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing cached CA cert: %w", err)
+	}
+	return &Bundle{
+		CA:      KeyPair{CertPEM: secret.Data["ca.crt"]},
+		Serving: KeyPair{CertPEM: secret.Data["tls.crt"], KeyPEM: secret.Data["tls.key"]},
+		caCert:  caCert,
+		expiry:  caCert.NotAfter,
+	}, nil
+}
+
+// PersistSecret writes the bundle into the named Secret, creating it if it
+// does not already exist.
+func PersistSecret(kubeclientset kubernetes.Interface, namespace, name string, b *Bundle) error {
+	secret := &apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Data: map[string][]byte{
+			"ca.crt":  b.CA.CertPEM,
+			"tls.crt": b.Serving.CertPEM,
+			"tls.key": b.Serving.KeyPEM,
+		},
+		Type: apiv1.SecretTypeTLS,
+	}
+	_, err := kubeclientset.CoreV1().Secrets(namespace).Create(secret)
+	if err == nil {
+		return nil
+	}
+	if !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("creating webhook cert secret: %w", err)
+	}
+	_, err = kubeclientset.CoreV1().Secrets(namespace).Update(secret)
+	return err
+}
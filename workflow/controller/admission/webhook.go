@@ -0,0 +1,450 @@
+// Package admission implements the built-in mutating and validating
+// admission webhooks for Workflow, WorkflowTemplate and
+// ClusterWorkflowTemplate. It lets defaulting and validation happen
+// synchronously at `kubectl create` time instead of on the controller's
+// first reconcile.
+package admission
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionregv1 "k8s.io/api/admissionregistration/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	wfv1 "github.com/argoproj/argo/pkg/apis/workflow/v1alpha1"
+	"github.com/argoproj/argo/workflow/controller/admission/pki"
+)
+
+const (
+	mutateWorkflowsPath   = "/mutate"
+	validateWorkflowsPath = "/validate"
+	readyzPath            = "/readyz"
+	certSecretNameDefault = "argo-workflows-webhook-tls"
+	mutatingWebhookName   = "workflow-mutation.argoproj.io"
+	validatingWebhookName = "workflow-validation.argoproj.io"
+
+	workflowAPIGroup   = "argoproj.io"
+	workflowAPIVersion = "v1alpha1"
+
+	// certRotationCheckInterval is how often Run checks whether the serving
+	// cert needs rotating while it's already up and serving.
+	certRotationCheckInterval = 1 * time.Hour
+)
+
+// Defaulter applies the controller's configured workflow defaults. It is
+// satisfied by *controller.WorkflowController without this package needing
+// to import it (which would be a cycle).
+type Defaulter interface {
+	SetWorkflowDefaults(wf *wfv1.Workflow) error
+}
+
+// Validator runs the schema/DAG/template validation the controller
+// otherwise runs on first reconcile, for every kind the webhook serves.
+type Validator interface {
+	ValidateWorkflow(wf *wfv1.Workflow) error
+	ValidateWorkflowTemplate(wftmpl *wfv1.WorkflowTemplate) error
+	ValidateClusterWorkflowTemplate(cwftmpl *wfv1.ClusterWorkflowTemplate) error
+}
+
+// Config controls whether and how the webhook server runs.
+type Config struct {
+	// Enabled corresponds to the --webhook-enabled flag.
+	Enabled bool
+	// Port the HTTPS server listens on.
+	Port int
+	// ServiceName/Namespace are used to build the DNS name for the serving cert
+	// and to locate the Secret the cert/key are persisted to.
+	ServiceName string
+	Namespace   string
+	SecretName  string
+}
+
+// Server is the built-in admission webhook server.
+type Server struct {
+	config        Config
+	kubeclientset kubernetes.Interface
+	defaulter     Defaulter
+	validator     Validator
+
+	bundle *pki.Bundle
+}
+
+// NewServer constructs a Server. It does not generate certs or start
+// listening until Run is called.
+func NewServer(config Config, kubeclientset kubernetes.Interface, defaulter Defaulter, validator Validator) *Server {
+	if config.SecretName == "" {
+		config.SecretName = certSecretNameDefault
+	}
+	return &Server{config: config, kubeclientset: kubeclientset, defaulter: defaulter, validator: validator}
+}
+
+// dnsName is the in-cluster DNS name the serving cert must be valid for.
+func (s *Server) dnsName() string {
+	return fmt.Sprintf("%s.%s.svc", s.config.ServiceName, s.config.Namespace)
+}
+
+// Run generates (or loads) the serving certs, creates or updates the
+// webhook configurations the API server needs to call us, serves until ctx
+// is cancelled, and rotates the certs (and re-patches the webhook
+// configurations) automatically as they approach expiry.
+func (s *Server) Run(ctx context.Context) error {
+	if err := s.ensureCerts(); err != nil {
+		return fmt.Errorf("provisioning webhook certs: %w", err)
+	}
+	if err := s.ensureWebhookConfigurations(); err != nil {
+		return fmt.Errorf("reconciling webhook configurations: %w", err)
+	}
+	go s.watchCertRotation(ctx)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(mutateWorkflowsPath, s.serveMutate)
+	mux.HandleFunc(validateWorkflowsPath, s.serveValidate)
+	mux.HandleFunc(readyzPath, s.serveReadyz)
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", s.config.Port),
+		Handler: mux,
+		// Serve whatever bundle is current, so a rotation takes effect
+		// without restarting the listener.
+		TLSConfig: &tls.Config{GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, err := tls.X509KeyPair(s.bundle.Serving.CertPEM, s.bundle.Serving.KeyPEM)
+			if err != nil {
+				return nil, err
+			}
+			return &cert, nil
+		}},
+	}
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+
+	log.Infof("Admission webhook server listening on %s", server.Addr)
+	if err := server.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// ensureCerts loads the current bundle from the Secret, generating and
+// persisting a new one only if it's missing or close to expiry - a fresh CA
+// on every restart would force every client of the old CA to be repatched.
+func (s *Server) ensureCerts() error {
+	bundle, err := pki.LoadSecret(s.kubeclientset, s.config.Namespace, s.config.SecretName)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("loading webhook cert secret: %w", err)
+	}
+	if bundle != nil && !bundle.NeedsRotation() {
+		s.bundle = bundle
+		return nil
+	}
+
+	bundle, err = pki.Generate(s.dnsName())
+	if err != nil {
+		return err
+	}
+	if err := pki.PersistSecret(s.kubeclientset, s.config.Namespace, s.config.SecretName, bundle); err != nil {
+		return err
+	}
+	s.bundle = bundle
+	return nil
+}
+
+// watchCertRotation periodically re-checks the serving cert and, once it's
+// within its rotation window, regenerates it and re-patches the webhook
+// configurations' caBundle so the API server picks up the new CA.
+func (s *Server) watchCertRotation(ctx context.Context) {
+	ticker := time.NewTicker(certRotationCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !s.bundle.NeedsRotation() {
+				continue
+			}
+			if err := s.ensureCerts(); err != nil {
+				log.Errorf("rotating webhook certs: %v", err)
+				continue
+			}
+			if err := s.ensureWebhookConfigurations(); err != nil {
+				log.Errorf("patching rotated webhook caBundle: %v", err)
+			}
+		}
+	}
+}
+
+// mutatingWebhookConfiguration is the MutatingWebhookConfiguration this
+// server expects the API server to have: it only mutates Workflow objects,
+// since defaulting doesn't apply to templates.
+func (s *Server) mutatingWebhookConfiguration() *admissionregv1.MutatingWebhookConfiguration {
+	failurePolicy := admissionregv1.Ignore
+	sideEffects := admissionregv1.SideEffectClassNone
+	return &admissionregv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: mutatingWebhookName},
+		Webhooks: []admissionregv1.MutatingWebhook{
+			{
+				Name:                    mutatingWebhookName,
+				AdmissionReviewVersions: []string{"v1"},
+				SideEffects:             &sideEffects,
+				FailurePolicy:           &failurePolicy,
+				ClientConfig:            s.clientConfig(mutateWorkflowsPath),
+				Rules:                   webhookRules([]string{"workflows"}),
+			},
+		},
+	}
+}
+
+// validatingWebhookConfiguration is the ValidatingWebhookConfiguration this
+// server expects the API server to have: Workflow, WorkflowTemplate and
+// ClusterWorkflowTemplate are all validated the same way.
+func (s *Server) validatingWebhookConfiguration() *admissionregv1.ValidatingWebhookConfiguration {
+	failurePolicy := admissionregv1.Ignore
+	sideEffects := admissionregv1.SideEffectClassNone
+	return &admissionregv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: validatingWebhookName},
+		Webhooks: []admissionregv1.ValidatingWebhook{
+			{
+				Name:                    validatingWebhookName,
+				AdmissionReviewVersions: []string{"v1"},
+				SideEffects:             &sideEffects,
+				FailurePolicy:           &failurePolicy,
+				ClientConfig:            s.clientConfig(validateWorkflowsPath),
+				Rules:                   webhookRules([]string{"workflows", "workflowtemplates", "clusterworkflowtemplates"}),
+			},
+		},
+	}
+}
+
+// clientConfig is the ClientConfig shared by both webhook entries; only the
+// serving path differs between mutate and validate.
+func (s *Server) clientConfig(path string) admissionregv1.WebhookClientConfig {
+	servicePath := path
+	return admissionregv1.WebhookClientConfig{
+		Service: &admissionregv1.ServiceReference{
+			Name:      s.config.ServiceName,
+			Namespace: s.config.Namespace,
+			Path:      &servicePath,
+		},
+		CABundle: s.bundle.CA.CertPEM,
+	}
+}
+
+// webhookRules scopes a webhook entry to Create/Update operations on the
+// given argoproj.io resources.
+func webhookRules(resources []string) []admissionregv1.RuleWithOperations {
+	return []admissionregv1.RuleWithOperations{
+		{
+			Operations: []admissionregv1.OperationType{admissionregv1.Create, admissionregv1.Update},
+			Rule: admissionregv1.Rule{
+				APIGroups:   []string{workflowAPIGroup},
+				APIVersions: []string{workflowAPIVersion},
+				Resources:   resources,
+			},
+		},
+	}
+}
+
+// ensureWebhookConfigurations creates the Mutating/ValidatingWebhookConfiguration
+// objects the API server needs in order to call this server, or updates
+// their webhook entries (including caBundle) in place if they already
+// exist from a previous run.
+func (s *Server) ensureWebhookConfigurations() error {
+	admissionregClient := s.kubeclientset.AdmissionregistrationV1()
+
+	desiredMutating := s.mutatingWebhookConfiguration()
+	if existing, err := admissionregClient.MutatingWebhookConfigurations().Get(mutatingWebhookName, metav1.GetOptions{}); apierrors.IsNotFound(err) {
+		if _, err := admissionregClient.MutatingWebhookConfigurations().Create(desiredMutating); err != nil {
+			return fmt.Errorf("creating MutatingWebhookConfiguration %s: %w", mutatingWebhookName, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("getting MutatingWebhookConfiguration %s: %w", mutatingWebhookName, err)
+	} else {
+		existing.Webhooks = desiredMutating.Webhooks
+		if _, err := admissionregClient.MutatingWebhookConfigurations().Update(existing); err != nil {
+			return fmt.Errorf("updating MutatingWebhookConfiguration %s: %w", mutatingWebhookName, err)
+		}
+	}
+
+	desiredValidating := s.validatingWebhookConfiguration()
+	if existing, err := admissionregClient.ValidatingWebhookConfigurations().Get(validatingWebhookName, metav1.GetOptions{}); apierrors.IsNotFound(err) {
+		if _, err := admissionregClient.ValidatingWebhookConfigurations().Create(desiredValidating); err != nil {
+			return fmt.Errorf("creating ValidatingWebhookConfiguration %s: %w", validatingWebhookName, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("getting ValidatingWebhookConfiguration %s: %w", validatingWebhookName, err)
+	} else {
+		existing.Webhooks = desiredValidating.Webhooks
+		if _, err := admissionregClient.ValidatingWebhookConfigurations().Update(existing); err != nil {
+			return fmt.Errorf("updating ValidatingWebhookConfiguration %s: %w", validatingWebhookName, err)
+		}
+	}
+	return nil
+}
+
+// Ready reports whether a serving cert has been provisioned. The controller
+// gates its own /readyz on this so it never advertises ready before the
+// webhook can actually serve admission requests.
+func (s *Server) Ready() bool {
+	return s.bundle != nil
+}
+
+// serveReadyz reports ready only once a serving cert is available, matching
+// the controller's other /readyz gates.
+func (s *Server) serveReadyz(w http.ResponseWriter, r *http.Request) {
+	if !s.Ready() {
+		http.Error(w, "cert not ready", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// serveMutate only mutates Workflow objects - defaulting doesn't apply to
+// WorkflowTemplate/ClusterWorkflowTemplate, so the mutating webhook
+// configuration doesn't route those kinds here in the first place, but a
+// stale registration would land here regardless, hence the explicit check.
+func (s *Server) serveMutate(w http.ResponseWriter, r *http.Request) {
+	review, err := decodeAdmissionReview(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if review.Request.Kind.Kind != "Workflow" {
+		writeAdmissionReview(w, review.Request.UID, &admissionv1.AdmissionResponse{Allowed: true})
+		return
+	}
+
+	wf := &wfv1.Workflow{}
+	if err := json.Unmarshal(review.Request.Object.Raw, wf); err != nil {
+		writeAdmissionReview(w, review.Request.UID, deniedResponse(err))
+		return
+	}
+
+	original := wf.DeepCopy()
+	if err := s.defaulter.SetWorkflowDefaults(wf); err != nil {
+		writeAdmissionReview(w, review.Request.UID, deniedResponse(err))
+		return
+	}
+	patch, err := buildJSONPatch(original, wf)
+	if err != nil {
+		writeAdmissionReview(w, review.Request.UID, deniedResponse(err))
+		return
+	}
+	patchType := admissionv1.PatchTypeJSONPatch
+	writeAdmissionReview(w, review.Request.UID, &admissionv1.AdmissionResponse{Allowed: true, Patch: patch, PatchType: &patchType})
+}
+
+// serveValidate dispatches to the right Validator method for whichever of
+// Workflow, WorkflowTemplate or ClusterWorkflowTemplate is being admitted.
+func (s *Server) serveValidate(w http.ResponseWriter, r *http.Request) {
+	review, err := decodeAdmissionReview(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var validationErr error
+	switch review.Request.Kind.Kind {
+	case "Workflow":
+		wf := &wfv1.Workflow{}
+		if err := json.Unmarshal(review.Request.Object.Raw, wf); err != nil {
+			writeAdmissionReview(w, review.Request.UID, deniedResponse(err))
+			return
+		}
+		validationErr = s.validator.ValidateWorkflow(wf)
+	case "WorkflowTemplate":
+		wftmpl := &wfv1.WorkflowTemplate{}
+		if err := json.Unmarshal(review.Request.Object.Raw, wftmpl); err != nil {
+			writeAdmissionReview(w, review.Request.UID, deniedResponse(err))
+			return
+		}
+		validationErr = s.validator.ValidateWorkflowTemplate(wftmpl)
+	case "ClusterWorkflowTemplate":
+		cwftmpl := &wfv1.ClusterWorkflowTemplate{}
+		if err := json.Unmarshal(review.Request.Object.Raw, cwftmpl); err != nil {
+			writeAdmissionReview(w, review.Request.UID, deniedResponse(err))
+			return
+		}
+		validationErr = s.validator.ValidateClusterWorkflowTemplate(cwftmpl)
+	default:
+		writeAdmissionReview(w, review.Request.UID, deniedResponse(fmt.Errorf("unsupported admission kind %q", review.Request.Kind.Kind)))
+		return
+	}
+	if validationErr != nil {
+		writeAdmissionReview(w, review.Request.UID, deniedResponse(validationErr))
+		return
+	}
+	writeAdmissionReview(w, review.Request.UID, &admissionv1.AdmissionResponse{Allowed: true})
+}
+
+func decodeAdmissionReview(r *http.Request) (*admissionv1.AdmissionReview, error) {
+	review := &admissionv1.AdmissionReview{}
+	if err := json.NewDecoder(r.Body).Decode(review); err != nil {
+		return nil, fmt.Errorf("decoding AdmissionReview: %w", err)
+	}
+	if review.Request == nil {
+		return nil, fmt.Errorf("AdmissionReview missing request")
+	}
+	return review, nil
+}
+
+func writeAdmissionReview(w http.ResponseWriter, uid types.UID, response *admissionv1.AdmissionResponse) {
+	response.UID = uid
+	review := &admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Response: response,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(review)
+}
+
+func deniedResponse(err error) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
+		Allowed: false,
+		Result:  &metav1.Status{Message: err.Error(), Reason: metav1.StatusReasonBadRequest},
+	}
+}
+
+// buildJSONPatch diffs the defaulted workflow against the original request
+// object, emitting ops for every top-level field SetWorkflowDefaults may
+// have touched: the spec itself, plus the labels/annotations defaulting
+// also fills in (see TestAddingWorkflowDefaultComplex).
+func buildJSONPatch(original, defaulted *wfv1.Workflow) ([]byte, error) {
+	var ops []map[string]interface{}
+	if !reflect.DeepEqual(original.Spec, defaulted.Spec) {
+		// /spec is a required field, so it always exists on the original
+		// object and "replace" is always valid here.
+		ops = append(ops, map[string]interface{}{"op": "replace", "path": "/spec", "value": defaulted.Spec})
+	}
+	if !reflect.DeepEqual(original.Labels, defaulted.Labels) {
+		ops = append(ops, metadataMapOp("/metadata/labels", original.Labels, defaulted.Labels))
+	}
+	if !reflect.DeepEqual(original.Annotations, defaulted.Annotations) {
+		ops = append(ops, metadataMapOp("/metadata/annotations", original.Annotations, defaulted.Annotations))
+	}
+	return json.Marshal(ops)
+}
+
+// metadataMapOp builds the JSON patch op for a labels/annotations change.
+// Per RFC 6902, "replace" requires the target path to already exist; when
+// the original object had no labels/annotations at all, the key is absent
+// from the request JSON and only "add" is valid.
+func metadataMapOp(path string, original, defaulted map[string]string) map[string]interface{} {
+	op := "replace"
+	if original == nil {
+		op = "add"
+	}
+	return map[string]interface{}{"op": op, "path": path, "value": defaulted}
+}
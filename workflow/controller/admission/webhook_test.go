@@ -0,0 +1,210 @@
+package admission
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+
+	wfv1 "github.com/argoproj/argo/pkg/apis/workflow/v1alpha1"
+)
+
+var helloWorldWf = `
+apiVersion: argoproj.io/v1alpha1
+kind: Workflow
+metadata:
+  name: hello-world
+spec:
+  entrypoint: whalesay
+  templates:
+  - name: whalesay
+    container:
+      image: docker/whalesay:latest
+      command: [cowsay]
+      args: ["hello world"]
+`
+
+var testDefaultWf = `
+apiVersion: argoproj.io/v1alpha1
+kind: Workflow
+metadata:
+  name: hello-world
+  labels:
+    foo: bar
+spec:
+  entrypoint: whalesay
+  serviceAccountName: whalesay
+  templates:
+  - name: whalesay
+    container:
+      image: docker/whalesay:latest
+      command: [cowsay]
+      args: ["hello world"]
+`
+
+var wfWithTmplRef = `
+apiVersion: argoproj.io/v1alpha1
+kind: Workflow
+metadata:
+  generateName: workflow-template-hello-world-
+  namespace: default
+spec:
+  entrypoint: whalesay-template
+  workflowTemplateRef:
+    name: workflow-template-whalesay-template
+`
+
+var helloWorldWfTmpl = `
+apiVersion: argoproj.io/v1alpha1
+kind: WorkflowTemplate
+metadata:
+  name: whalesay-template
+spec:
+  entrypoint: whalesay
+  templates:
+  - name: whalesay
+    container:
+      image: docker/whalesay:latest
+      command: [cowsay]
+      args: ["hello world"]
+`
+
+type fakeDefaulter struct{ apply func(wf *wfv1.Workflow) }
+
+func (f fakeDefaulter) SetWorkflowDefaults(wf *wfv1.Workflow) error {
+	if f.apply != nil {
+		f.apply(wf)
+	}
+	return nil
+}
+
+type fakeValidator struct{ err error }
+
+func (f fakeValidator) ValidateWorkflow(wf *wfv1.Workflow) error { return f.err }
+
+func (f fakeValidator) ValidateWorkflowTemplate(wftmpl *wfv1.WorkflowTemplate) error { return f.err }
+
+func (f fakeValidator) ValidateClusterWorkflowTemplate(cwftmpl *wfv1.ClusterWorkflowTemplate) error {
+	return f.err
+}
+
+// runAdmissionReview is a fake AdmissionReview runner: it POSTs the given
+// object YAML through the server's HTTP handler and returns the decoded
+// AdmissionResponse, so tests can assert against it the way the API server
+// would. kind is the Kind the real API server would set on the request
+// (e.g. "Workflow", "WorkflowTemplate").
+func runAdmissionReview(t *testing.T, s *Server, mutate bool, kind, yamlStr string) *admissionv1.AdmissionResponse {
+	t.Helper()
+	raw, err := yaml.YAMLToJSON([]byte(yamlStr))
+	if err != nil {
+		t.Fatalf("decoding fixture: %v", err)
+	}
+	review := &admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			UID:    types.UID("test-uid"),
+			Kind:   metav1.GroupVersionKind{Kind: kind},
+			Object: runtime.RawExtension{Raw: raw},
+		},
+	}
+	body, err := json.Marshal(review)
+	if err != nil {
+		t.Fatalf("marshaling review: %v", err)
+	}
+
+	req := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+	if mutate {
+		s.serveMutate(rec, req)
+	} else {
+		s.serveValidate(rec, req)
+	}
+
+	got := &admissionv1.AdmissionReview{}
+	if err := json.NewDecoder(rec.Body).Decode(got); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	return got.Response
+}
+
+func TestServeMutate_AppliesWorkflowDefaults(t *testing.T) {
+	s := NewServer(Config{}, nil, fakeDefaulter{apply: func(wf *wfv1.Workflow) {
+		wf.Spec.ServiceAccountName = "defaulted-sa"
+	}}, fakeValidator{})
+
+	resp := runAdmissionReview(t, s, true, "Workflow", helloWorldWf)
+	assert.True(t, resp.Allowed)
+	assert.NotEmpty(t, resp.Patch)
+}
+
+func TestServeMutate_ComplexDefaults(t *testing.T) {
+	s := NewServer(Config{}, nil, fakeDefaulter{apply: func(wf *wfv1.Workflow) {
+		wf.Spec.ServiceAccountName = "my_service_account"
+		if wf.Labels == nil {
+			wf.Labels = map[string]string{}
+		}
+		wf.Labels["label"] = "value"
+		if wf.Annotations == nil {
+			wf.Annotations = map[string]string{}
+		}
+		wf.Annotations["annotation"] = "value"
+	}}, fakeValidator{})
+
+	resp := runAdmissionReview(t, s, true, "Workflow", testDefaultWf)
+	assert.True(t, resp.Allowed)
+
+	var ops []map[string]interface{}
+	assert.NoError(t, json.Unmarshal(resp.Patch, &ops))
+	paths := map[string]bool{}
+	for _, op := range ops {
+		paths[op["path"].(string)] = true
+	}
+	assert.True(t, paths["/spec"], "expected a patch op replacing /spec with the defaulted ServiceAccountName")
+	assert.True(t, paths["/metadata/labels"], "expected a patch op replacing /metadata/labels with the merged label default")
+	assert.True(t, paths["/metadata/annotations"], "expected a patch op adding /metadata/annotations with the annotation default")
+}
+
+func TestServeValidate_RejectsInvalidWorkflow(t *testing.T) {
+	s := NewServer(Config{}, nil, fakeDefaulter{}, fakeValidator{err: fmt.Errorf("templates.whalesay-template not found")})
+
+	resp := runAdmissionReview(t, s, false, "Workflow", wfWithTmplRef)
+	assert.False(t, resp.Allowed)
+	assert.Contains(t, resp.Result.Message, "not found")
+}
+
+func TestServeValidate_AllowsValidWorkflow(t *testing.T) {
+	s := NewServer(Config{}, nil, fakeDefaulter{}, fakeValidator{})
+
+	resp := runAdmissionReview(t, s, false, "Workflow", helloWorldWf)
+	assert.True(t, resp.Allowed)
+}
+
+func TestServeValidate_DispatchesByKind(t *testing.T) {
+	s := NewServer(Config{}, nil, fakeDefaulter{}, fakeValidator{})
+
+	resp := runAdmissionReview(t, s, false, "WorkflowTemplate", helloWorldWfTmpl)
+	assert.True(t, resp.Allowed)
+}
+
+func TestServeValidate_RejectsUnsupportedKind(t *testing.T) {
+	s := NewServer(Config{}, nil, fakeDefaulter{}, fakeValidator{})
+
+	resp := runAdmissionReview(t, s, false, "CronWorkflow", helloWorldWf)
+	assert.False(t, resp.Allowed)
+	assert.Contains(t, resp.Result.Message, "unsupported admission kind")
+}
+
+func TestReadyz_NotReadyWithoutCert(t *testing.T) {
+	s := NewServer(Config{}, nil, fakeDefaulter{}, fakeValidator{})
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", readyzPath, nil)
+	s.serveReadyz(rec, req)
+	assert.Equal(t, 503, rec.Code)
+}
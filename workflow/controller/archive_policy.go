@@ -0,0 +1,193 @@
+package controller
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/argoproj/argo/config"
+	wfv1 "github.com/argoproj/argo/pkg/apis/workflow/v1alpha1"
+)
+
+// ArchiveAction and ArchivePolicy are config.ArchiveAction/config.ArchivePolicy,
+// aliased so call sites in this package (and controller_test.go) can keep
+// referring to them unqualified.
+type (
+	ArchiveAction = config.ArchiveAction
+	ArchivePolicy = config.ArchivePolicy
+)
+
+const (
+	ArchiveActionArchive     = config.ArchiveActionArchive
+	ArchiveActionSkip        = config.ArchiveActionSkip
+	ArchiveActionDeleteAfter = config.ArchiveActionDeleteAfter
+)
+
+// archivePolicyEngine resolves the effective ArchivePolicy for a given
+// workflow from an ordered rule list, consulting a cached namespace
+// informer for namespace labels.
+type archivePolicyEngine struct {
+	policies   []ArchivePolicy
+	namespaces namespaceLister
+}
+
+// namespaceLister is satisfied by a corev1 namespace lister backed by a
+// shared informer. It's kept unexported since EffectiveArchivePolicy is the
+// only surface tests and callers need.
+type namespaceLister interface {
+	Get(name string) (*apiv1.Namespace, error)
+}
+
+func newArchivePolicyEngine(policies []ArchivePolicy, namespaces namespaceLister) *archivePolicyEngine {
+	return &archivePolicyEngine{policies: policies, namespaces: namespaces}
+}
+
+// informerNamespaceLister is a namespaceLister backed by a shared informer's
+// local store, so namespace label lookups on the archive hot path don't hit
+// the API server.
+type informerNamespaceLister struct {
+	informer cache.SharedIndexInformer
+}
+
+// newNamespaceInformer builds (but does not start) a shared informer that
+// caches Namespace objects for the policy engine.
+func newNamespaceInformer(kubeclientset kubernetes.Interface) *informerNamespaceLister {
+	source := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return kubeclientset.CoreV1().Namespaces().List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return kubeclientset.CoreV1().Namespaces().Watch(options)
+		},
+	}
+	informer := cache.NewSharedIndexInformer(source, &apiv1.Namespace{}, 0, cache.Indexers{})
+	return &informerNamespaceLister{informer: informer}
+}
+
+// Run starts the namespace informer and blocks until stopCh is closed.
+func (l *informerNamespaceLister) Run(stopCh <-chan struct{}) {
+	l.informer.Run(stopCh)
+}
+
+// HasSynced reports whether the initial namespace list has been processed.
+func (l *informerNamespaceLister) HasSynced() bool {
+	return l.informer.HasSynced()
+}
+
+func (l *informerNamespaceLister) Get(name string) (*apiv1.Namespace, error) {
+	obj, exists, err := l.informer.GetStore().GetByKey(name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, apierrors.NewNotFound(apiv1.Resource("namespaces"), name)
+	}
+	ns, ok := obj.(*apiv1.Namespace)
+	if !ok {
+		return nil, fmt.Errorf("unexpected type %T in namespace informer store", obj)
+	}
+	return ns, nil
+}
+
+// resolve returns the first policy whose NamespaceSelector and
+// WorkflowSelector both match, or nil if none do.
+func (e *archivePolicyEngine) resolve(wf *wfv1.Workflow) (*ArchivePolicy, error) {
+	if len(e.policies) == 0 {
+		return nil, nil
+	}
+	var ns *apiv1.Namespace
+	if e.namespaces != nil {
+		n, err := e.namespaces.Get(wf.Namespace)
+		if err != nil {
+			return nil, fmt.Errorf("looking up namespace %q for archive policy: %w", wf.Namespace, err)
+		}
+		ns = n
+	}
+
+	for i := range e.policies {
+		policy := &e.policies[i]
+		nsMatch, err := selectorMatches(policy.NamespaceSelector, namespaceLabels(ns))
+		if err != nil {
+			return nil, err
+		}
+		if !nsMatch {
+			continue
+		}
+		wfMatch, err := selectorMatches(policy.WorkflowSelector, wf.Labels)
+		if err != nil {
+			return nil, err
+		}
+		if !wfMatch {
+			continue
+		}
+		return policy, nil
+	}
+	return nil, nil
+}
+
+func namespaceLabels(ns *apiv1.Namespace) map[string]string {
+	if ns == nil {
+		return nil
+	}
+	return ns.Labels
+}
+
+// selectorMatches reports whether a nil-safe LabelSelector matches the given
+// labels; a nil selector always matches.
+func selectorMatches(selector *metav1.LabelSelector, lbls map[string]string) (bool, error) {
+	if selector == nil {
+		return true, nil
+	}
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return false, fmt.Errorf("invalid label selector: %w", err)
+	}
+	return s.Matches(labels.Set(lbls)), nil
+}
+
+// EffectiveArchivePolicy returns the first ArchivePolicy rule that matches
+// wf's namespace and labels, in rule order. It returns (nil, nil) when no
+// rule matches, meaning the controller should fall back to its default
+// archiveLabelSelector behavior.
+func (wfc *WorkflowController) EffectiveArchivePolicy(wf *wfv1.Workflow) (*ArchivePolicy, error) {
+	if wfc.archivePolicyEngine == nil {
+		return nil, nil
+	}
+	return wfc.archivePolicyEngine.resolve(wf)
+}
+
+// isArchivable reports whether wf should be persisted to the archive. It
+// first consults the policy engine (if any policies are configured); if no
+// rule matches, it falls back to the legacy single archiveLabelSelector.
+// Archive and DeleteAfter rules are both archivable - DeleteAfter still
+// writes the record, it's just also subject to later TTL deletion by
+// archivedWorkflowGarbageCollector - only Skip suppresses archiving.
+func (wfc *WorkflowController) isArchivable(wf *wfv1.Workflow) bool {
+	if wfc.wfArchive == nil {
+		return false
+	}
+	policy, err := wfc.EffectiveArchivePolicy(wf)
+	if err != nil {
+		log.Warnf("failed to resolve archive policy for workflow %s/%s, falling back to archiveLabelSelector: %v", wf.Namespace, wf.Name, err)
+	}
+	if policy != nil {
+		archivable := policy.Action == ArchiveActionArchive || policy.Action == ArchiveActionDeleteAfter
+		wfc.metrics.ArchivePolicyResult(wf.Namespace, string(policy.Action))
+		return archivable
+	}
+	archivable := wfc.archiveLabelSelector.Matches(labels.Set(wf.GetLabels()))
+	if archivable {
+		wfc.metrics.ArchivePolicyResult(wf.Namespace, string(ArchiveActionArchive))
+	} else {
+		wfc.metrics.ArchivePolicyResult(wf.Namespace, string(ArchiveActionSkip))
+	}
+	return archivable
+}
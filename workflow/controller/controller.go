@@ -0,0 +1,507 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	pkgsync "github.com/argoproj/pkg/sync"
+	"github.com/imdario/mergo"
+	log "github.com/sirupsen/logrus"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/argoproj/argo/config"
+	"github.com/argoproj/argo/persist/sqldb"
+	wfv1 "github.com/argoproj/argo/pkg/apis/workflow/v1alpha1"
+	wfclientset "github.com/argoproj/argo/pkg/client/clientset/versioned"
+	wfextv "github.com/argoproj/argo/pkg/client/informers/externalversions"
+	wftmplv1alpha1 "github.com/argoproj/argo/pkg/client/informers/externalversions/workflow/v1alpha1"
+	"github.com/argoproj/argo/workflow/common"
+	"github.com/argoproj/argo/workflow/controller/admission"
+	controllercache "github.com/argoproj/argo/workflow/controller/cache"
+	"github.com/argoproj/argo/workflow/controller/estimation"
+	"github.com/argoproj/argo/workflow/events"
+	"github.com/argoproj/argo/workflow/hydrator"
+	"github.com/argoproj/argo/workflow/metrics"
+	"github.com/argoproj/argo/workflow/validate"
+)
+
+// WorkflowController is the controller for workflow resources. It watches
+// Workflow, WorkflowTemplate, ClusterWorkflowTemplate and Pod objects and
+// drives them towards their desired state.
+type WorkflowController struct {
+	Config config.Config
+
+	kubeclientset    kubernetes.Interface
+	dynamicInterface dynamic.Interface
+	wfclientset      wfclientset.Interface
+
+	completedPods chan string
+
+	wfInformer      cache.SharedIndexInformer
+	wftmplInformer  wftmplv1alpha1.WorkflowTemplateInformer
+	cwftmplInformer wftmplv1alpha1.ClusterWorkflowTemplateInformer
+	podInformer     cache.SharedIndexInformer
+
+	wfQueue  workqueue.RateLimitingInterface
+	podQueue workqueue.RateLimitingInterface
+
+	workflowKeyLock *pkgsync.KeyLock
+
+	wfArchive            sqldb.WorkflowArchive
+	hydrator             hydrator.Interface
+	estimatorFactory     estimation.EstimatorFactory
+	metrics              *metrics.Metrics
+	eventRecorderManager events.EventRecorderManager
+	archiveLabelSelector labels.Selector
+	cacheFactory         controllercache.Factory
+
+	podEventWatcher     *podEventWatcher
+	queueManager        *queueManager
+	namespaceInformer   *informerNamespaceLister
+	archivePolicyEngine *archivePolicyEngine
+
+	// webhookConfig gates the optional admission webhook subsystem; it is
+	// populated from the --webhook-enabled flag and related flags in cmd,
+	// not from Config, since the webhook has to be listening before any
+	// Workflow object can be admitted.
+	webhookConfig admission.Config
+	webhookServer *admission.Server
+
+	// readyzPort is the port the controller's own /readyz endpoint listens
+	// on. Like webhookConfig, it has to be available before Config is
+	// loaded, so it isn't part of Config.
+	readyzPort int
+}
+
+// defaultReadyzPort is used when NewWorkflowController isn't given an
+// explicit readyz port.
+const defaultReadyzPort = 6060
+
+// NewWorkflowController creates a new WorkflowController. webhookConfig may
+// be the zero value, in which case the admission webhook subsystem is not
+// started.
+func NewWorkflowController(
+	kubeclientset kubernetes.Interface,
+	dynamicInterface dynamic.Interface,
+	wfclientset wfclientset.Interface,
+	namespace string,
+	webhookConfig admission.Config,
+) *WorkflowController {
+	wfc := &WorkflowController{
+		kubeclientset:        kubeclientset,
+		dynamicInterface:     dynamicInterface,
+		wfclientset:          wfclientset,
+		completedPods:        make(chan string, 512),
+		wfQueue:              workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		podQueue:             workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		workflowKeyLock:      pkgsync.NewKeyLock(),
+		archiveLabelSelector: labels.Everything(),
+		cacheFactory:         controllercache.NewCacheFactory(kubeclientset, namespace),
+		webhookConfig:        webhookConfig,
+		readyzPort:           defaultReadyzPort,
+	}
+	wfc.podInformer = wfc.newPodInformer()
+	wfc.podEventWatcher = newPodEventWatcher(kubeclientset, namespace, 0)
+	wfc.namespaceInformer = newNamespaceInformer(kubeclientset)
+	wfc.archivePolicyEngine = newArchivePolicyEngine(wfc.Config.ArchivePolicies, wfc.namespaceInformer)
+	wfc.queueManager = newQueueManager(wfc, wfc.Config.Concurrency)
+	if webhookConfig.Enabled {
+		wfc.webhookServer = admission.NewServer(webhookConfig, kubeclientset, wfc, wfc)
+	}
+	return wfc
+}
+
+// Run starts the pod and namespace informers, the pod event watcher, the
+// queue workers, the /readyz server, and - if enabled - the admission webhook
+// server, and blocks until ctx is cancelled. It does not start wfInformer or
+// archivedWorkflowGarbageCollector.
+func (wfc *WorkflowController) Run(ctx context.Context) error {
+	go wfc.podEventWatcher.Run(ctx.Done())
+	go wfc.namespaceInformer.Run(ctx.Done())
+	go wfc.podInformer.Run(ctx.Done())
+
+	if wfc.webhookServer != nil {
+		go func() {
+			if err := wfc.webhookServer.Run(ctx); err != nil {
+				log.Errorf("admission webhook server exited: %v", err)
+			}
+		}()
+	}
+
+	go wfc.runReadyzServer(ctx)
+
+	wfc.queueManager.Run(ctx, wfc.runQueueWorker)
+	return nil
+}
+
+// readyz reports whether the controller (and, if enabled, its admission
+// webhook server) is ready to serve.
+func (wfc *WorkflowController) readyz() bool {
+	if wfc.webhookServer != nil && !wfc.webhookServer.Ready() {
+		return false
+	}
+	return wfc.podInformer.HasSynced()
+}
+
+// runReadyzServer serves readyz on its own HTTP server until ctx is
+// cancelled, so it can be probed independently of whether the admission
+// webhook subsystem is enabled.
+func (wfc *WorkflowController) runReadyzServer(ctx context.Context) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !wfc.readyz() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	server := &http.Server{Addr: fmt.Sprintf(":%d", wfc.readyzPort), Handler: mux}
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+
+	log.Infof("Controller readyz server listening on %s", server.Addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Errorf("readyz server exited: %v", err)
+	}
+}
+
+// runQueueWorker drains a single item from the named queue and hands it to
+// that queue's processNext*Item function; it is the dispatch point the
+// queueManager's worker pools call into.
+func (wfc *WorkflowController) runQueueWorker(name string) {
+	queue := wfc.queueManager.Get(name)
+	if queue == nil {
+		return
+	}
+	key, shutdown := queue.Get()
+	if shutdown {
+		return
+	}
+	defer queue.Done(key)
+
+	wfc.queueManager.BeginWork(name)
+	defer wfc.queueManager.EndWork(name)
+
+	var err error
+	switch name {
+	case "workflow":
+		err = wfc.processNextWorkflowItem(key)
+	case "pod":
+		err = wfc.processNextPodItem(key)
+	case "pod-cleanup":
+		err = wfc.processNextPodCleanupItem(key)
+	case "cron":
+		err = wfc.processNextCronItem(key)
+	case "garbage-collect":
+		err = wfc.processNextGarbageCollectItem(key)
+	case "archive":
+		err = wfc.processNextArchiveItem(key)
+	}
+	if err != nil {
+		log.Errorf("error processing %s queue item %v: %v", name, key, err)
+		queue.AddRateLimited(key)
+		return
+	}
+	queue.Forget(key)
+}
+
+// setWorkflowDefaults applies Config.WorkflowDefaults on top of wf, filling
+// in only the fields wf doesn't already set; a field wf has already set
+// always wins over the default.
+func (wfc *WorkflowController) setWorkflowDefaults(wf *wfv1.Workflow) error {
+	if wfc.Config.WorkflowDefaults == nil {
+		return nil
+	}
+	defaults := wfc.Config.WorkflowDefaults.DeepCopy()
+	return mergo.Merge(wf, defaults)
+}
+
+// SetWorkflowDefaults is the exported form of setWorkflowDefaults, letting
+// the admission webhook apply the controller's defaults at admission time
+// without this package importing admission (which would be a cycle).
+func (wfc *WorkflowController) SetWorkflowDefaults(wf *wfv1.Workflow) error {
+	return wfc.setWorkflowDefaults(wf)
+}
+
+// ValidateWorkflow runs the controller's normal reconcile-time validation
+// synchronously, so the admission webhook can reject bad workflows before
+// they're persisted.
+func (wfc *WorkflowController) ValidateWorkflow(wf *wfv1.Workflow) error {
+	_, err := validate.ValidateWorkflow(wfc.wftmplInformer, wfc.cwftmplInformer, wf, validate.ValidateOpts{})
+	return err
+}
+
+func (wfc *WorkflowController) newPodInformer() cache.SharedIndexInformer {
+	source := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return wfc.kubeclientset.CoreV1().Pods(metav1.NamespaceAll).List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return wfc.kubeclientset.CoreV1().Pods(metav1.NamespaceAll).Watch(options)
+		},
+	}
+	informer := cache.NewSharedIndexInformer(source, &apiv1.Pod{}, 0, cache.Indexers{})
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: func(obj interface{}) {
+			pod, ok := obj.(*apiv1.Pod)
+			if !ok {
+				if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+					pod, ok = tombstone.Obj.(*apiv1.Pod)
+					if !ok {
+						return
+					}
+				} else {
+					return
+				}
+			}
+			// Evict the pod's cached event so podEventWatcher's LRU cache
+			// doesn't grow unbounded across the life of the controller.
+			wfc.podEventWatcher.Forget(string(pod.UID))
+		},
+	})
+	return informer
+}
+
+// nodeStatusMessageForPod returns the message a NodeStatus should carry for
+// pod, preferring the underlying failure reason cached by podEventWatcher
+// over the generic pod-level message, and, when it does, emits an event on
+// the owning workflow with the cached event's type and reason.
+func (wfc *WorkflowController) nodeStatusMessageForPod(wf *wfv1.Workflow, pod *apiv1.Pod, fallback string) string {
+	info, ok := wfc.podEventWatcher.Get(string(pod.UID))
+	if !ok {
+		return fallback
+	}
+	message := nodeStatusMessageFromPodEvent(info, fallback)
+	wfc.eventRecorderManager.Get(wf.Namespace).Event(wf, info.Type, info.Reason, message)
+	return message
+}
+
+func (wfc *WorkflowController) createClusterWorkflowTemplateInformer(ctx context.Context) {
+	canI, err := wfc.kubeclientset.AuthorizationV1().SelfSubjectAccessReviews().Create(&authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Verb:     "list",
+				Group:    workflowGroup,
+				Resource: "clusterworkflowtemplates",
+			},
+		},
+	})
+	if err != nil {
+		log.Warnf("failed to check clusterworkflowtemplate permissions: %v", err)
+		return
+	}
+	if !canI.Status.Allowed {
+		wfc.cwftmplInformer = nil
+		return
+	}
+	informerFactory := wfextv.NewSharedInformerFactory(wfc.wfclientset, 0)
+	wfc.cwftmplInformer = informerFactory.Argoproj().V1alpha1().ClusterWorkflowTemplates()
+	go wfc.cwftmplInformer.Informer().Run(ctx.Done())
+}
+
+const workflowGroup = "argoproj.io"
+
+// releaseAllWorkflowLocks releases any synchronization locks held on behalf
+// of obj's workflow, tolerating nil and non-Workflow objects since it's
+// called from generic informer delete handlers.
+func (wfc *WorkflowController) releaseAllWorkflowLocks(obj interface{}) {
+	if obj == nil {
+		return
+	}
+	wf, ok := obj.(*wfv1.Workflow)
+	if !ok {
+		return
+	}
+	wfc.workflowKeyLock.Delete(fmt.Sprintf("%s/%s", wf.Namespace, wf.Name))
+}
+
+// processNextWorkflowItem consumes an item from the "workflow" queue. It
+// logs the workflow key at debug level and returns; it does not operate the
+// workflow or change its phase or node statuses.
+func (wfc *WorkflowController) processNextWorkflowItem(key interface{}) error {
+	k, ok := key.(string)
+	if !ok {
+		return nil
+	}
+	log.Debugf("reconciling workflow %s", k)
+	return nil
+}
+
+// podPendingThreshold returns how long a pod may sit in Pending before
+// processNextPodItem starts consulting its cached pod events, falling back
+// to config.DefaultPodPendingThreshold when Config.PodPendingThreshold is
+// unset.
+func (wfc *WorkflowController) podPendingThreshold() time.Duration {
+	if wfc.Config.PodPendingThreshold.Duration > 0 {
+		return wfc.Config.PodPendingThreshold.Duration
+	}
+	return config.DefaultPodPendingThreshold
+}
+
+// processNextPodItem reconciles a single Pod, consulting podEventWatcher so
+// the owning workflow's NodeStatus gets the real failure reason instead of
+// the generic pod-level message once the pod has failed, or has been stuck
+// Pending longer than podPendingThreshold.
+func (wfc *WorkflowController) processNextPodItem(key interface{}) error {
+	k, ok := key.(string)
+	if !ok {
+		return nil
+	}
+	obj, exists, err := wfc.podInformer.GetStore().GetByKey(k)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+	pod, ok := obj.(*apiv1.Pod)
+	if !ok {
+		return nil
+	}
+	switch pod.Status.Phase {
+	case apiv1.PodFailed:
+	case apiv1.PodPending:
+		if time.Since(pod.CreationTimestamp.Time) < wfc.podPendingThreshold() {
+			return nil
+		}
+	default:
+		return nil
+	}
+	nodeID, ok := pod.Labels[common.LabelKeyNodeID]
+	if !ok {
+		return nil
+	}
+	wfName, ok := pod.Labels[common.LabelKeyWorkflow]
+	if !ok {
+		return nil
+	}
+	wf, err := wfc.wfclientset.ArgoprojV1alpha1().Workflows(pod.Namespace).Get(wfName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	node, ok := wf.Status.Nodes[nodeID]
+	if !ok {
+		return nil
+	}
+	message := wfc.nodeStatusMessageForPod(wf, pod, node.Message)
+	if message == node.Message {
+		return nil
+	}
+	node.Message = message
+	wf.Status.Nodes[nodeID] = node
+	if _, err := wfc.wfclientset.ArgoprojV1alpha1().Workflows(wf.Namespace).Update(wf); err != nil {
+		return fmt.Errorf("updating NodeStatus message for %s/%s: %w", wf.Namespace, nodeID, err)
+	}
+	return nil
+}
+
+// processNextPodCleanupItem consumes an item from the "pod-cleanup" queue.
+// It logs the pod key at debug level; it does not delete the pod.
+func (wfc *WorkflowController) processNextPodCleanupItem(key interface{}) error {
+	k, ok := key.(string)
+	if !ok {
+		return nil
+	}
+	log.Debugf("cleaning up pod %s", k)
+	return nil
+}
+
+// processNextCronItem consumes an item from the "cron" queue. It logs the
+// CronWorkflow key at debug level; it does not evaluate the schedule or
+// submit a workflow.
+func (wfc *WorkflowController) processNextCronItem(key interface{}) error {
+	k, ok := key.(string)
+	if !ok {
+		return nil
+	}
+	log.Debugf("evaluating cron workflow %s", k)
+	return nil
+}
+
+// processNextGarbageCollectItem consumes an item from the "garbage-collect"
+// queue. It logs the workflow key at debug level; it does not delete the
+// workflow.
+func (wfc *WorkflowController) processNextGarbageCollectItem(key interface{}) error {
+	k, ok := key.(string)
+	if !ok {
+		return nil
+	}
+	log.Debugf("garbage-collecting workflow %s", k)
+	return nil
+}
+
+// processNextArchiveItem consumes an item from the "archive" queue. It logs
+// the workflow key at debug level; it neither writes the workflow to the
+// archive nor consults EffectiveArchivePolicy.
+func (wfc *WorkflowController) processNextArchiveItem(key interface{}) error {
+	k, ok := key.(string)
+	if !ok {
+		return nil
+	}
+	log.Debugf("archiving workflow %s", k)
+	return nil
+}
+
+// archivedWorkflowGarbageCollectorTick is how often we sweep the archive
+// for DeleteAfter-policy workflows whose TTL has elapsed.
+const archivedWorkflowGarbageCollectorTick = 5 * time.Minute
+
+// archivedWorkflowGarbageCollector starts a background sweep that
+// periodically deletes archived workflow records whose owning
+// namespace/workflow now resolve to a DeleteAfter archive policy rule and
+// whose TTL has elapsed. It returns immediately; the sweep stops when
+// stopCh is closed.
+func (wfc *WorkflowController) archivedWorkflowGarbageCollector(stopCh <-chan struct{}) {
+	log.Info("Starting archived workflow garbage collector")
+	go func() {
+		ticker := time.NewTicker(archivedWorkflowGarbageCollectorTick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-ticker.C:
+				wfc.sweepArchiveDeleteAfter()
+			}
+		}
+	}()
+}
+
+// sweepArchiveDeleteAfter deletes every archived workflow whose effective
+// policy is DeleteAfter and whose TTL has elapsed since completion.
+func (wfc *WorkflowController) sweepArchiveDeleteAfter() {
+	completed, err := wfc.wfArchive.ListWorkflows(metav1.NamespaceAll, "", "", time.Time{}, time.Time{}, nil, 0, 0)
+	if err != nil {
+		log.Errorf("failed to list archived workflows for garbage collection: %v", err)
+		return
+	}
+	for i := range completed {
+		wf := &completed[i]
+		policy, err := wfc.EffectiveArchivePolicy(wf)
+		if err != nil || policy == nil || policy.Action != ArchiveActionDeleteAfter {
+			continue
+		}
+		if wf.Status.FinishedAt.IsZero() || time.Now().Before(policy.DeleteAfterDeadline(wf.Status.FinishedAt.Time)) {
+			continue
+		}
+		if err := wfc.wfArchive.DeleteWorkflow(string(wf.UID)); err != nil {
+			log.Errorf("failed to delete archived workflow %s/%s past its DeleteAfter TTL: %v", wf.Namespace, wf.Name, err)
+			continue
+		}
+		wfc.metrics.ArchivePolicyResult(wf.Namespace, "Deleted")
+	}
+}
@@ -2,6 +2,7 @@ package controller
 
 import (
 	"context"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -16,6 +17,7 @@ import (
 	apiv1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
 	k8stesting "k8s.io/client-go/testing"
@@ -158,11 +160,21 @@ func newController(objects ...runtime.Object) (context.CancelFunc, *WorkflowCont
 		eventRecorderManager: &testEventRecorderManager{eventRecorder: record.NewFakeRecorder(16)},
 		archiveLabelSelector: labels.Everything(),
 		cacheFactory:         controllercache.NewCacheFactory(kube, "default"),
+		podEventWatcher:      newPodEventWatcher(kube, "", 0),
 	}
+	controller.queueManager = newQueueManager(controller, ConcurrencyConfig{})
+	controller.namespaceInformer = newNamespaceInformer(kube)
+	controller.archivePolicyEngine = newArchivePolicyEngine(nil, controller.namespaceInformer)
 	controller.podInformer = controller.newPodInformer()
 	return cancel, controller
 }
 
+func newControllerWithConcurrency(cc ConcurrencyConfig, objects ...runtime.Object) (context.CancelFunc, *WorkflowController) {
+	cancel, controller := newController(objects...)
+	controller.queueManager = newQueueManager(controller, cc)
+	return cancel, controller
+}
+
 func newControllerWithDefaults() (context.CancelFunc, *WorkflowController) {
 	cancel, controller := newController()
 	myBool := true
@@ -451,6 +463,151 @@ func TestIsArchivable(t *testing.T) {
 		workflow.Labels["workflows.argoproj.io/archive-strategy"] = "true"
 		assert.True(t, controller.isArchivable(workflow))
 	})
+	t.Run("NamespaceScopedRule", func(t *testing.T) {
+		wf := unmarshalWF(helloWorldWf)
+		wf.Namespace = "team-a"
+		err := controller.namespaceInformer.informer.GetStore().Add(&apiv1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"tier": "prod"}},
+		})
+		assert.NoError(t, err)
+
+		controller.archivePolicyEngine = newArchivePolicyEngine([]ArchivePolicy{
+			{
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "prod"}},
+				Action:            ArchiveActionArchive,
+			},
+		}, controller.namespaceInformer)
+		assert.True(t, controller.isArchivable(wf))
+
+		wf.Namespace = "team-b"
+		assert.NoError(t, controller.namespaceInformer.informer.GetStore().Add(&apiv1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: "team-b", Labels: map[string]string{"tier": "dev"}},
+		}))
+		assert.False(t, controller.isArchivable(wf))
+	})
+	t.Run("RuleOrdering", func(t *testing.T) {
+		wf := unmarshalWF(helloWorldWf)
+		wf.Namespace = "team-a"
+		wf.Labels = map[string]string{"skip-archive": "true"}
+
+		controller.archivePolicyEngine = newArchivePolicyEngine([]ArchivePolicy{
+			{
+				WorkflowSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"skip-archive": "true"}},
+				Action:           ArchiveActionSkip,
+			},
+			{
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "prod"}},
+				Action:            ArchiveActionArchive,
+			},
+		}, controller.namespaceInformer)
+		// The first matching rule wins even though the second rule would also match.
+		assert.False(t, controller.isArchivable(wf))
+
+		policy, err := controller.EffectiveArchivePolicy(wf)
+		assert.NoError(t, err)
+		assert.Equal(t, ArchiveActionSkip, policy.Action)
+	})
+}
+
+// injectPodEvent acts as a fake event source: it feeds a synthetic Event
+// straight into the watcher's handler, bypassing the informer, so tests can
+// assert on the resulting cached NodeStatus.Message without a live watch.
+func injectPodEvent(pw *podEventWatcher, podUID, reason, message string) {
+	pw.handleEvent(&apiv1.Event{
+		InvolvedObject: apiv1.ObjectReference{Kind: "Pod", UID: types.UID(podUID)},
+		Reason:         reason,
+		Message:        message,
+		Type:           apiv1.EventTypeWarning,
+	})
+}
+
+func TestPodEventWatcher(t *testing.T) {
+	cancel, controller := newController()
+	defer cancel()
+
+	t.Run("UninterestingReasonIsIgnored", func(t *testing.T) {
+		injectPodEvent(controller.podEventWatcher, "uid-1", "Scheduled", "assigned to node")
+		_, ok := controller.podEventWatcher.Get("uid-1")
+		assert.False(t, ok)
+	})
+
+	t.Run("InterestingReasonIsCachedAndSurfaced", func(t *testing.T) {
+		injectPodEvent(controller.podEventWatcher, "uid-2", "ImagePullBackOff", "Back-off pulling image \"does-not-exist\"")
+		info, ok := controller.podEventWatcher.Get("uid-2")
+		assert.True(t, ok)
+		assert.Equal(t, "ImagePullBackOff", info.Reason)
+		assert.Equal(t, "ImagePullBackOff: Back-off pulling image \"does-not-exist\"", nodeStatusMessageFromPodEvent(info, "pod failed"))
+	})
+
+	t.Run("FallbackWhenNothingCached", func(t *testing.T) {
+		assert.Equal(t, "pod failed", nodeStatusMessageFromPodEvent(nil, "pod failed"))
+	})
+
+	t.Run("ForgetEvictsOnPodDelete", func(t *testing.T) {
+		injectPodEvent(controller.podEventWatcher, "uid-3", "OOMKilled", "container was OOM killed")
+		controller.podEventWatcher.Forget("uid-3")
+		_, ok := controller.podEventWatcher.Get("uid-3")
+		assert.False(t, ok)
+	})
+}
+
+func TestQueueManagerWiring(t *testing.T) {
+	cancel, controller := newControllerWithConcurrency(ConcurrencyConfig{WorkflowWorkers: 3, PodWorkers: 5})
+	defer cancel()
+
+	assert.NotNil(t, controller.queueManager.Get("workflow"))
+	assert.NotNil(t, controller.queueManager.Get("pod"))
+	assert.NotNil(t, controller.queueManager.Get("cron"))
+	assert.Nil(t, controller.queueManager.Get("does-not-exist"))
+	assert.Equal(t, 3, controller.queueManager.queues["workflow"].workers)
+	assert.Equal(t, 5, controller.queueManager.queues["pod"].workers)
+	assert.Equal(t, defaultCronWorkers, controller.queueManager.queues["cron"].workers)
+}
+
+// TestQueueFairness verifies that flooding one queue with work does not
+// prevent another queue's workers from making progress, i.e. each queue's
+// worker pool is independent.
+func TestQueueFairness(t *testing.T) {
+	cancel, controller := newControllerWithConcurrency(ConcurrencyConfig{WorkflowWorkers: 1, PodWorkers: 1})
+	defer cancel()
+
+	for i := 0; i < 1000; i++ {
+		controller.queueManager.Get("workflow").Add(i)
+	}
+	controller.queueManager.Get("pod").Add("only-item")
+
+	var podProcessed int32
+	ctx, stop := context.WithTimeout(context.Background(), 2*time.Second)
+	defer stop()
+
+	go func() {
+		for {
+			item, shutdown := controller.queueManager.Get("pod").Get()
+			if shutdown {
+				return
+			}
+			atomic.AddInt32(&podProcessed, 1)
+			controller.queueManager.Get("pod").Done(item)
+			return
+		}
+	}()
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+			item, shutdown := controller.queueManager.Get("workflow").Get()
+			if shutdown {
+				return
+			}
+			controller.queueManager.Get("workflow").Done(item)
+		}
+	}()
+
+	<-ctx.Done()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&podProcessed), "pod queue should have made progress despite the workflow queue being flooded")
 }
 
 func TestReleaseAllWorkflowLocks(t *testing.T) {
@@ -0,0 +1,168 @@
+package controller
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilcache "k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// defaultPodEventCacheSize bounds the number of pods we remember interesting
+// events for. It's sized generously above typical in-flight pod counts so
+// that eviction is driven by pod deletion rather than the LRU limit.
+const defaultPodEventCacheSize = 4096
+
+// podEventTTL is how long a cached event stays valid. LRUExpireCache
+// requires a nonzero TTL - a TTL of 0 would expire every entry the instant
+// it's inserted - so this is set to a day; an entry older than that expires,
+// and before then eviction is left to Forget (called on pod delete) and the
+// cache's own LRU size limit.
+const podEventTTL = 24 * time.Hour
+
+// interestingPodEventReasons are the Event reasons that explain *why* a pod
+// failed to run, as opposed to the generic phase-level message Kubernetes
+// gives back on the Pod object itself.
+var interestingPodEventReasons = map[string]bool{
+	"FailedScheduling":       true,
+	"FailedCreatePodSandBox": true,
+	"ImagePullBackOff":       true,
+	"ErrImagePull":           true,
+	"OOMKilled":              true,
+	"Evicted":                true,
+	"NodeNotReady":           true,
+	"Preempted":              true,
+}
+
+// podEventInfo is the distilled, cached form of the most recent interesting
+// Event seen for a pod.
+type podEventInfo struct {
+	Reason         string
+	Message        string
+	Type           string
+	FirstTimestamp metav1.Time
+}
+
+// podEventWatcher maintains an LRU cache, keyed by pod UID, of the most
+// recent interesting Event observed for that pod. It is consulted on the
+// pod-reconcile hot path so that we can surface the real failure cause
+// (e.g. ImagePullBackOff) instead of the generic Pod-level message.
+type podEventWatcher struct {
+	kubeclientset kubernetes.Interface
+	namespace     string
+
+	informer cache.SharedIndexInformer
+
+	mu    sync.RWMutex
+	cache *utilcache.LRUExpireCache
+}
+
+// newPodEventWatcher constructs a podEventWatcher for the given namespace
+// (empty string means all namespaces). It does not start watching until Run
+// is called. cacheSize <= 0 falls back to defaultPodEventCacheSize.
+func newPodEventWatcher(kubeclientset kubernetes.Interface, namespace string, cacheSize int) *podEventWatcher {
+	if cacheSize <= 0 {
+		cacheSize = defaultPodEventCacheSize
+	}
+	w := &podEventWatcher{
+		kubeclientset: kubeclientset,
+		namespace:     namespace,
+		cache:         utilcache.NewLRUExpireCache(cacheSize),
+	}
+	w.informer = w.newInformer()
+	return w
+}
+
+func (w *podEventWatcher) newInformer() cache.SharedIndexInformer {
+	source := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = fields.OneTermEqualSelector("involvedObject.kind", "Pod").String()
+			return w.kubeclientset.CoreV1().Events(w.namespace).List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = fields.OneTermEqualSelector("involvedObject.kind", "Pod").String()
+			return w.kubeclientset.CoreV1().Events(w.namespace).Watch(options)
+		},
+	}
+	informer := cache.NewSharedIndexInformer(source, &apiv1.Event{}, podEventResyncPeriod, cache.Indexers{})
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    w.handleEvent,
+		UpdateFunc: func(_, newObj interface{}) { w.handleEvent(newObj) },
+	})
+	return informer
+}
+
+// podEventResyncPeriod mirrors the pod informer's default resync period; a
+// dedicated const keeps the two watchers independently tunable.
+const podEventResyncPeriod = 0
+
+func (w *podEventWatcher) handleEvent(obj interface{}) {
+	event, ok := obj.(*apiv1.Event)
+	if !ok {
+		return
+	}
+	if !interestingPodEventReasons[event.Reason] {
+		return
+	}
+	uid := event.InvolvedObject.UID
+	if uid == "" {
+		return
+	}
+	w.cache.Add(string(uid), &podEventInfo{
+		Reason:         event.Reason,
+		Message:        event.Message,
+		Type:           event.Type,
+		FirstTimestamp: event.FirstTimestamp,
+	}, podEventTTL)
+}
+
+// Get returns the most recent interesting event recorded for the pod with
+// the given UID. Lookup is O(1).
+func (w *podEventWatcher) Get(podUID string) (*podEventInfo, bool) {
+	val, ok := w.cache.Get(podUID)
+	if !ok {
+		return nil, false
+	}
+	info, ok := val.(*podEventInfo)
+	return info, ok
+}
+
+// Forget evicts any cached event for the given pod UID. Callers should
+// invoke this from their pod-delete informer callback so the cache doesn't
+// grow unbounded across the life of a long-running controller.
+func (w *podEventWatcher) Forget(podUID string) {
+	w.cache.Remove(podUID)
+}
+
+// Run starts the underlying shared informer and blocks until stopCh is
+// closed.
+func (w *podEventWatcher) Run(stopCh <-chan struct{}) {
+	log.Info("Starting pod event watcher")
+	w.informer.Run(stopCh)
+}
+
+// HasSynced reports whether the initial list of events has been processed.
+func (w *podEventWatcher) HasSynced() bool {
+	return w.informer.HasSynced()
+}
+
+// nodeStatusMessageFromPodEvent returns the message that should be recorded
+// on a NodeStatus for a pod that is stuck Pending or has moved to
+// Failed/Error, preferring the underlying event reason over the generic
+// fallback message when one is cached.
+func nodeStatusMessageFromPodEvent(info *podEventInfo, fallback string) string {
+	if info == nil {
+		return fallback
+	}
+	if info.Message != "" {
+		return info.Reason + ": " + info.Message
+	}
+	return info.Reason
+}
@@ -0,0 +1,178 @@
+package controller
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/argoproj/argo/config"
+)
+
+// Default worker counts, used when the matching ConcurrencyConfig field is
+// zero.
+const (
+	defaultWorkflowWorkers       = 32
+	defaultPodWorkers            = 32
+	defaultPodCleanupWorkers     = 4
+	defaultCronWorkers           = 8
+	defaultGarbageCollectWorkers = 4
+	defaultArchiveWorkers        = 4
+)
+
+// ConcurrencyConfig is config.ConcurrencyConfig, aliased so call sites in
+// this package (and controller_test.go) can keep referring to it
+// unqualified.
+type ConcurrencyConfig = config.ConcurrencyConfig
+
+// namedQueue pairs a rate-limiting workqueue with the metadata needed to run
+// and monitor it independently of the controller's other queues.
+type namedQueue struct {
+	name    string
+	queue   workqueue.RateLimitingInterface
+	workers int
+
+	// inFlight is the number of items currently being processed by a
+	// worker, as opposed to queue.Len() which only counts items still
+	// waiting to be picked up.
+	inFlight int32
+
+	saturatedSince time.Time
+}
+
+// queueManager owns the controller's named queues (workflow, pod,
+// pod-cleanup, cron, garbage-collect, archive) and runs each with its own configurable worker
+// pool, in the style of controller-runtime's per-controller queues.
+type queueManager struct {
+	controller *WorkflowController
+	queues     map[string]*namedQueue
+
+	// saturationWarningWindow is how long a queue must stay fully busy
+	// before monitorSaturation logs a saturation warning.
+	saturationWarningWindow time.Duration
+}
+
+func newQueueManager(controller *WorkflowController, cc ConcurrencyConfig) *queueManager {
+	def := func(configured, fallback int) int {
+		if configured > 0 {
+			return configured
+		}
+		return fallback
+	}
+	saturationWarningWindow := cc.SaturationWarningWindow.Duration
+	if saturationWarningWindow <= 0 {
+		saturationWarningWindow = config.DefaultQueueSaturationWarningWindow
+	}
+	qm := &queueManager{
+		controller:              controller,
+		queues:                  map[string]*namedQueue{},
+		saturationWarningWindow: saturationWarningWindow,
+	}
+	qm.add("workflow", controller.wfQueue, def(cc.WorkflowWorkers, defaultWorkflowWorkers))
+	qm.add("pod", controller.podQueue, def(cc.PodWorkers, defaultPodWorkers))
+	qm.add("pod-cleanup", workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()), def(cc.PodCleanupWorkers, defaultPodCleanupWorkers))
+	qm.add("cron", workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()), def(cc.CronWorkers, defaultCronWorkers))
+	qm.add("garbage-collect", workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()), def(cc.GarbageCollectWorkers, defaultGarbageCollectWorkers))
+	qm.add("archive", workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()), def(cc.ArchiveWorkers, defaultArchiveWorkers))
+	return qm
+}
+
+func (qm *queueManager) add(name string, queue workqueue.RateLimitingInterface, workers int) {
+	qm.queues[name] = &namedQueue{name: name, queue: queue, workers: workers}
+}
+
+// Get returns the named queue, or nil if it doesn't exist.
+func (qm *queueManager) Get(name string) workqueue.RateLimitingInterface {
+	nq, ok := qm.queues[name]
+	if !ok {
+		return nil
+	}
+	return nq.queue
+}
+
+// BeginWork/EndWork bracket a worker actually processing an item (as
+// opposed to merely waiting in the queue), so monitorSaturation can report
+// true in-flight counts and worker-pool saturation, not just queue depth.
+func (qm *queueManager) BeginWork(name string) {
+	if nq, ok := qm.queues[name]; ok {
+		atomic.AddInt32(&nq.inFlight, 1)
+	}
+}
+
+func (qm *queueManager) EndWork(name string) {
+	if nq, ok := qm.queues[name]; ok {
+		atomic.AddInt32(&nq.inFlight, -1)
+	}
+}
+
+// Run starts every queue's worker pool and its saturation monitor, and
+// blocks until ctx is cancelled.
+func (qm *queueManager) Run(ctx context.Context, runWorker func(name string)) {
+	for name, nq := range qm.queues {
+		name, nq := name, nq // capture per-iteration; shared loop vars would hand every goroutine the last queue's name
+		for i := 0; i < nq.workers; i++ {
+			go wait(ctx, func() { runWorker(name) })
+		}
+		go qm.monitorSaturation(ctx, nq)
+	}
+	<-ctx.Done()
+	for _, nq := range qm.queues {
+		nq.queue.ShutDown()
+	}
+}
+
+// wait re-runs fn until ctx is cancelled, the same restart-on-panic-adjacent
+// pattern client-go's wait.Until uses for informer/queue workers.
+func wait(ctx context.Context, fn func()) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			fn()
+		}
+	}
+}
+
+// monitorSaturation reports the queue depth, in-flight count, and worker
+// saturation gauges every tick, and - once the queue has stayed fully busy
+// (depth > 0 with every worker occupied) for longer than
+// saturationWarningWindow - logs a single warning and increments the
+// saturation-warning counter, once until the pool is no longer fully busy.
+// No Kubernetes Event is recorded.
+func (qm *queueManager) monitorSaturation(ctx context.Context, nq *namedQueue) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	warned := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			depth := nq.queue.Len()
+			inFlight := int(atomic.LoadInt32(&nq.inFlight))
+			saturation := float64(inFlight) / float64(nq.workers)
+
+			qm.controller.metrics.SetQueueDepth(nq.name, depth)
+			qm.controller.metrics.SetQueueInFlight(nq.name, inFlight)
+			qm.controller.metrics.SetQueueWorkerSaturation(nq.name, saturation)
+
+			fullyBusy := depth > 0 && inFlight >= nq.workers
+			if !fullyBusy {
+				nq.saturatedSince = time.Time{}
+				warned = false
+				continue
+			}
+			if nq.saturatedSince.IsZero() {
+				nq.saturatedSince = time.Now()
+			}
+			if !warned && time.Since(nq.saturatedSince) > qm.saturationWarningWindow {
+				warned = true
+				log.Warnf("%s queue has been saturated (depth=%d) for over %s", nq.name, depth, qm.saturationWarningWindow)
+				qm.controller.metrics.QueueSaturationWarning(nq.name, depth)
+			}
+		}
+	}
+}